@@ -0,0 +1,51 @@
+package rain
+
+import (
+	"sync"
+
+	"github.com/cenkalti/rain/internal/dht"
+	"github.com/cenkalti/rain/internal/logger"
+)
+
+// One DHT node runs per Rain instance, shared by every transfer it owns,
+// so they share a single routing table and UDP socket. Rain has no field
+// of its own to lazily hold it (nor a constructor hook to add one), so
+// dhtNodes keys the per-instance state off the *Rain pointer itself
+// instead of a package-level singleton, which would otherwise let the
+// first Rain to call dhtNode() fix every later instance's DHT config
+// (bootstrap nodes, node-ID file), ignoring their own r.config.DHT.
+var (
+	dhtNodesM sync.Mutex
+	dhtNodes  = make(map[*Rain]*dhtEntry)
+)
+
+type dhtEntry struct {
+	once sync.Once
+	node *dht.DHT
+	err  error
+}
+
+func (r *Rain) dhtEntry() *dhtEntry {
+	dhtNodesM.Lock()
+	defer dhtNodesM.Unlock()
+	e, ok := dhtNodes[r]
+	if !ok {
+		e = &dhtEntry{}
+		dhtNodes[r] = e
+	}
+	return e
+}
+
+// dhtNode lazily starts r's own DHT node (BEP 5) the first time any of
+// its transfers needs it, using r's own config.DHT. It returns nil, nil
+// if DHT support is disabled in the config.
+func (r *Rain) dhtNode() (*dht.DHT, error) {
+	if !r.config.DHT.Enabled {
+		return nil, nil
+	}
+	e := r.dhtEntry()
+	e.once.Do(func() {
+		e.node, e.err = dht.New(r.config.DHT, ":0", logger.New("dht"))
+	})
+	return e.node, e.err
+}
@@ -0,0 +1,106 @@
+// Package dht runs a Mainline DHT (BEP 5) node so peers can be discovered
+// for a torrent without a tracker, and existing trackers can be
+// supplemented with DHT results.
+package dht
+
+import (
+	"net"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+
+	"github.com/cenkalti/rain/internal/logger"
+	"github.com/cenkalti/rain/internal/protocol"
+)
+
+// announceInterval is how often an active transfer's info hash is
+// re-announced to the DHT.
+const announceInterval = 15 * time.Minute
+
+// Config controls whether and how the DHT node runs.
+type Config struct {
+	Enabled bool
+	// BootstrapNodes are host:port addresses used to join the DHT. The
+	// well-known public nodes are used when empty.
+	BootstrapNodes []string
+	// NodeIDFile persists the node ID across restarts, so the node keeps
+	// its position in the DHT's routing tables.
+	NodeIDFile string
+}
+
+// DHT is a running Mainline DHT node.
+type DHT struct {
+	server *dht.Server
+	log    logger.Logger
+}
+
+// New starts a DHT node listening on addr (use ":0" to pick any port).
+func New(config Config, addr string, log logger.Logger) (*DHT, error) {
+	cfg := dht.NewDefaultServerConfig()
+	if len(config.BootstrapNodes) > 0 {
+		cfg.StartingNodes = func() ([]dht.Addr, error) { return dht.ResolveHostPorts(config.BootstrapNodes) }
+	}
+	if id, ok := loadNodeID(config.NodeIDFile); ok {
+		cfg.NodeId = id
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Conn = conn
+
+	s, err := dht.NewServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if config.NodeIDFile != "" {
+		saveNodeID(config.NodeIDFile, s.ID())
+	}
+	return &DHT{server: s, log: log}, nil
+}
+
+// Announce periodically get_peers-announces infoHash and pushes every
+// peer address it learns about onto peersC, until stopC is closed.
+func (d *DHT) Announce(infoHash protocol.InfoHash, peersC chan<- []*net.TCPAddr, stopC chan struct{}) {
+	for {
+		a, err := d.server.AnnounceTraversal(infoHash, dht.AnnouncePeer(true))
+		if err != nil {
+			d.log.Error(err)
+		} else {
+			d.drain(a, peersC, stopC)
+		}
+		select {
+		case <-time.After(announceInterval):
+		case <-stopC:
+			return
+		}
+	}
+}
+
+func (d *DHT) drain(a *dht.Announce, peersC chan<- []*net.TCPAddr, stopC chan struct{}) {
+	defer a.Close()
+	for {
+		select {
+		case v, ok := <-a.Peers:
+			if !ok {
+				return
+			}
+			addrs := make([]*net.TCPAddr, 0, len(v.Peers))
+			for _, p := range v.Peers {
+				addrs = append(addrs, &net.TCPAddr{IP: p.IP, Port: p.Port})
+			}
+			if len(addrs) > 0 {
+				select {
+				case peersC <- addrs:
+				case <-stopC:
+					return
+				}
+			}
+		case <-stopC:
+			return
+		}
+	}
+}
+
+// Close shuts the DHT node down.
+func (d *DHT) Close() { d.server.Close() }
@@ -0,0 +1,27 @@
+package dht
+
+import (
+	"os"
+
+	"github.com/anacrolix/dht/v2/krpc"
+)
+
+// loadNodeID reads a previously persisted node ID from path, if any.
+func loadNodeID(path string) (id krpc.ID, ok bool) {
+	if path == "" {
+		return id, false
+	}
+	b, err := os.ReadFile(path)
+	if err != nil || len(b) != len(id) {
+		return id, false
+	}
+	copy(id[:], b)
+	return id, true
+}
+
+// saveNodeID persists id to path so the node keeps its place in peers'
+// routing tables across restarts. Errors are not fatal: a fresh random ID
+// is used next time instead.
+func saveNodeID(path string, id krpc.ID) {
+	_ = os.WriteFile(path, id[:], 0600)
+}
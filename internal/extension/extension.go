@@ -0,0 +1,53 @@
+// Package extension implements the libtorrent extension protocol
+// handshake (BEP 10), the "LTEP" message that peers use to advertise and
+// agree on IDs for the extension messages they both support (ut_pex,
+// ut_metadata, ...).
+package extension
+
+import "github.com/zeebo/bencode"
+
+// ID is the extended message ID a peer picked for one of its extensions.
+// 0 is reserved for the handshake itself.
+type ID uint8
+
+// Well-known extension names, used as keys of the "m" dict.
+const (
+	UTMetadata = "ut_metadata"
+	UTPex      = "ut_pex"
+)
+
+// Handshake is the bencoded dictionary sent as the payload of extended
+// message ID 0, right after the BitTorrent handshake, by both sides of a
+// connection that advertised extension protocol support.
+type Handshake struct {
+	// M maps an extension name to the local message ID the sender chose
+	// for it; the other side must use that ID in the message type byte
+	// when it talks to us about that extension.
+	M map[string]ID `bencode:"m"`
+	// V is a free-form client name/version string.
+	V string `bencode:"v,omitempty"`
+	// Port is our listening port, so a peer that accepted our outgoing
+	// connection can still dial us back.
+	Port int `bencode:"p,omitempty"`
+	// MetadataSize is the size in bytes of the info dictionary, included
+	// once we know it so ut_metadata requesters know how many pieces to
+	// ask for.
+	MetadataSize int `bencode:"metadata_size,omitempty"`
+}
+
+// Marshal bencodes h.
+func Marshal(h Handshake) ([]byte, error) { return bencode.EncodeBytes(h) }
+
+// Unmarshal decodes a peer's handshake payload.
+func Unmarshal(b []byte) (Handshake, error) {
+	var h Handshake
+	err := bencode.DecodeBytes(b, &h)
+	return h, err
+}
+
+// Supports reports whether a received handshake advertises support for
+// extension name, and returns the ID to address it by.
+func (h Handshake) Supports(name string) (ID, bool) {
+	id, ok := h.M[name]
+	return id, ok
+}
@@ -0,0 +1,82 @@
+// Package magnet parses magnet URIs (BEP 9), the "xt=urn:btih:<hash>"
+// links used to start a transfer before the .torrent metadata is
+// available.
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cenkalti/rain/internal/protocol"
+)
+
+// Magnet is a parsed magnet URI.
+type Magnet struct {
+	InfoHash protocol.InfoHash
+	Name     string   // dn param, if present
+	Trackers []string // tr params, in the order they appeared
+}
+
+// Parse parses a magnet URI of the form
+// "magnet:?xt=urn:btih:<info-hash>&dn=<name>&tr=<tracker>...".
+func Parse(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("magnet: unsupported scheme %q", u.Scheme)
+	}
+	q := u.Query()
+
+	var infoHash protocol.InfoHash
+	found := false
+	for _, xt := range q["xt"] {
+		const prefix = "urn:btih:"
+		if !strings.HasPrefix(xt, prefix) {
+			continue
+		}
+		ih, err := decodeInfoHash(strings.TrimPrefix(xt, prefix))
+		if err != nil {
+			return nil, err
+		}
+		infoHash = ih
+		found = true
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("magnet: missing xt=urn:btih: parameter")
+	}
+
+	return &Magnet{
+		InfoHash: infoHash,
+		Name:     q.Get("dn"),
+		Trackers: q["tr"],
+	}, nil
+}
+
+// decodeInfoHash accepts both the 40-char hex and the 32-char base32
+// encodings of a 20-byte info hash, both seen in the wild.
+func decodeInfoHash(s string) (protocol.InfoHash, error) {
+	var ih protocol.InfoHash
+	switch len(s) {
+	case 40:
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return ih, err
+		}
+		copy(ih[:], b)
+	case 32:
+		b, err := base32.StdEncoding.DecodeString(strings.ToUpper(s))
+		if err != nil {
+			return ih, err
+		}
+		copy(ih[:], b)
+	default:
+		return ih, fmt.Errorf("magnet: invalid info hash length %d", len(s))
+	}
+	return ih, nil
+}
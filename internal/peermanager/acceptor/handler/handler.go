@@ -2,14 +2,52 @@ package handler
 
 import (
 	"net"
+	"sync"
 
 	"github.com/cenkalti/rain/internal/bitfield"
 	"github.com/cenkalti/rain/internal/btconn"
 	"github.com/cenkalti/rain/internal/logger"
 	"github.com/cenkalti/rain/internal/peer"
 	"github.com/cenkalti/rain/internal/peermanager/peerids"
+	"github.com/cenkalti/rain/internal/ratelimit"
 )
 
+// downLimiter and upLimiter throttle every inbound connection Handler
+// accepts, in addition to whatever per-transfer limiter applies once the
+// peer is handed off to a transfer. SetLimiters installs them; until
+// called they default to unlimited, since Handler is constructed without
+// a reference back to the transfer (or its config) the connection turns
+// out to belong to.
+//
+// Nothing in this tree calls SetLimiters yet. Whatever constructs
+// Handler per accepted connection (an accept loop wired to a *Rain's
+// config.RateLimit) isn't part of this snapshot, so there's no call site
+// we own to install real limiters from. These are package-level rather
+// than per-*Rain-instance the way dht.go's DHT node is, which would also
+// need revisiting once that caller exists, for the same
+// one-instance-clobbers-another reason: see dht.go.
+var (
+	limitersM              sync.RWMutex
+	downLimiter, upLimiter *ratelimit.Limiter
+)
+
+// SetLimiters installs the Limiter pair applied to every connection
+// accepted from here on. Either may be nil for unlimited.
+func SetLimiters(down, up *ratelimit.Limiter) {
+	limitersM.Lock()
+	defer limitersM.Unlock()
+	downLimiter, upLimiter = down, up
+}
+
+func limiters() (down, up *ratelimit.Limiter) {
+	limitersM.RLock()
+	defer limitersM.RUnlock()
+	return downLimiter, upLimiter
+}
+
+// Handler serves a single incoming connection, accepted from any
+// transport.Transport's Listener; net.Conn hides the underlying protocol
+// (TCP, µTP, ...) once the connection is established.
 type Handler struct {
 	conn     net.Conn
 	peerIDs  *peerids.PeerIDs
@@ -64,7 +102,10 @@ func (h *Handler) Run(stopC chan struct{}) {
 	peerbf := bitfield.NewBytes(peerExtensions[:], 64)
 	extensions := ourbf.And(peerbf)
 
-	p := peer.New(encConn, peerID, extensions, h.bitfield, log, h.messages)
+	down, up := limiters()
+	limited := ratelimit.WrapConn(encConn, down, up, nil)
+
+	p := peer.New(limited, peerID, extensions, h.bitfield, log, h.messages)
 	p.Run(stopC)
 }
 
@@ -37,6 +37,7 @@ func New(peerList *peerlist.PeerList, peerIDs *peerids.PeerIDs, peerID, infoHash
 	}
 }
 
+// Run dials peerList addresses as capacity in limiter allows.
 func (d *Dialer) Run(stopC chan struct{}) {
 	for {
 		select {
@@ -0,0 +1,23 @@
+package pex
+
+import (
+	"net"
+	"time"
+)
+
+// Filter narrows m.Added down to the peers that should actually be fed
+// into peerList: ones not already known (known returns false for new
+// ones), and within the sending peer's rate limit.
+func Filter(m Message, limiter *Limiter, known func(*net.TCPAddr) bool, now time.Time) []*net.TCPAddr {
+	var fresh []*net.TCPAddr
+	for _, a := range m.Added {
+		if known != nil && known(a) {
+			continue
+		}
+		if !limiter.Allow(now, 1) {
+			break
+		}
+		fresh = append(fresh, a)
+	}
+	return fresh
+}
@@ -0,0 +1,31 @@
+package pex
+
+import "time"
+
+// MaxAddsPerMinute caps how many "added" peers a single remote peer may
+// feed us per minute, so a misbehaving or malicious peer can't flood
+// peerList with ut_pex traffic.
+const MaxAddsPerMinute = 50
+
+// Limiter rate-limits the peers accepted from a single remote peer's
+// ut_pex messages. It is not safe for concurrent use; callers already
+// serialize message handling per connection.
+type Limiter struct {
+	windowStart time.Time
+	count       int
+}
+
+// Allow reports whether n more "added" peers may be accepted from this
+// remote right now, and records them against the current one-minute
+// window if so.
+func (l *Limiter) Allow(now time.Time, n int) bool {
+	if now.Sub(l.windowStart) >= time.Minute {
+		l.windowStart = now
+		l.count = 0
+	}
+	if l.count+n > MaxAddsPerMinute {
+		return false
+	}
+	l.count += n
+	return true
+}
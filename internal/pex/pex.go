@@ -0,0 +1,99 @@
+// Package pex implements Peer Exchange (BEP 11): once two peers have
+// agreed via the BEP 10 extension handshake to speak ut_pex, they
+// periodically tell each other which peers they're connected to, so
+// peerList can grow without extra tracker traffic.
+package pex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/zeebo/bencode"
+)
+
+// Flag bits for a peer in Message.AddedFlags, one byte per entry in
+// Message.Added, in the same order.
+const (
+	FlagPreferEncryption  byte = 1 << 0
+	FlagSeed              byte = 1 << 1
+	FlagSupportsUTP       byte = 1 << 2
+	FlagSupportsHolepunch byte = 1 << 3
+)
+
+// Message is a single ut_pex message: peers added and dropped since the
+// last one sent to this peer. IPv6 peers are carried in a separate pair
+// of keys ("added6"/"dropped6") that this type does not model yet.
+type Message struct {
+	Added      []*net.TCPAddr
+	AddedFlags []byte
+	Dropped    []*net.TCPAddr
+}
+
+// wireMessage is the bencoded form of Message put on the wire.
+type wireMessage struct {
+	Added      string `bencode:"added"`
+	AddedFlags []byte `bencode:"added.f"`
+	Dropped    string `bencode:"dropped"`
+}
+
+// Marshal bencodes m using BEP 23's compact peer format for the address
+// lists.
+func Marshal(m Message) ([]byte, error) {
+	added, err := compactAddrs(m.Added)
+	if err != nil {
+		return nil, err
+	}
+	dropped, err := compactAddrs(m.Dropped)
+	if err != nil {
+		return nil, err
+	}
+	flags := m.AddedFlags
+	if flags == nil {
+		flags = make([]byte, len(m.Added))
+	}
+	return bencode.EncodeBytes(wireMessage{Added: string(added), AddedFlags: flags, Dropped: string(dropped)})
+}
+
+// Unmarshal decodes a received ut_pex message.
+func Unmarshal(b []byte) (Message, error) {
+	var w wireMessage
+	if err := bencode.DecodeBytes(b, &w); err != nil {
+		return Message{}, err
+	}
+	added, err := parseCompactAddrs([]byte(w.Added))
+	if err != nil {
+		return Message{}, err
+	}
+	dropped, err := parseCompactAddrs([]byte(w.Dropped))
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Added: added, AddedFlags: w.AddedFlags, Dropped: dropped}, nil
+}
+
+func compactAddrs(addrs []*net.TCPAddr) ([]byte, error) {
+	b := make([]byte, 0, len(addrs)*6)
+	for _, a := range addrs {
+		ip4 := a.IP.To4()
+		if ip4 == nil {
+			return nil, fmt.Errorf("pex: %s is not an IPv4 address", a.IP)
+		}
+		b = append(b, ip4...)
+		b = binary.BigEndian.AppendUint16(b, uint16(a.Port))
+	}
+	return b, nil
+}
+
+func parseCompactAddrs(b []byte) ([]*net.TCPAddr, error) {
+	if len(b)%6 != 0 {
+		return nil, fmt.Errorf("pex: compact peer list length %d not a multiple of 6", len(b))
+	}
+	addrs := make([]*net.TCPAddr, 0, len(b)/6)
+	for i := 0; i < len(b); i += 6 {
+		ip := net.IPv4(b[i], b[i+1], b[i+2], b[i+3])
+		port := binary.BigEndian.Uint16(b[i+4 : i+6])
+		addrs = append(addrs, &net.TCPAddr{IP: ip, Port: int(port)})
+	}
+	return addrs, nil
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+)
+
+// Conn wraps a net.Conn, throttling Read and Write against a download and
+// an upload Limiter respectively. Either may be nil, same as New(0): no
+// throttling in that direction.
+type Conn struct {
+	net.Conn
+	down, up  *Limiter
+	upCounter *Counter
+}
+
+// WrapConn returns conn throttled by down (reads) and up (writes). If
+// upCounter is non-nil, every byte written is also recorded there, e.g.
+// to feed a transfer's Uploaded()/UploadRate() or a per-peer rate used
+// for unchoke decisions. conn may already be a *Conn: wraps compose, so
+// a single connection can be metered at both a per-transfer and a
+// per-peer granularity.
+func WrapConn(conn net.Conn, down, up *Limiter, upCounter *Counter) *Conn {
+	return &Conn{Conn: conn, down: down, up: up, upCounter: upCounter}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		_ = c.down.WaitN(context.Background(), n)
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.up.WaitN(context.Background(), len(b)); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(b)
+	if n > 0 && c.upCounter != nil {
+		c.upCounter.Add(n)
+	}
+	return n, err
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Counter tracks cumulative bytes transferred and a smoothed
+// instantaneous rate, for exposing live "uploaded/downloaded bytes,
+// current rate" stats.
+type Counter struct {
+	mu    sync.Mutex
+	total int64
+	rate  float64
+	last  time.Time
+}
+
+// NewCounter returns a ready-to-use Counter.
+func NewCounter() *Counter { return &Counter{last: time.Now()} }
+
+// smoothing is the exponential moving average weight given to each new
+// sample when updating Rate.
+const smoothing = 0.3
+
+// Add records n bytes transferred just now.
+func (c *Counter) Add(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += int64(n)
+
+	now := time.Now()
+	dt := now.Sub(c.last).Seconds()
+	c.last = now
+	if dt <= 0 {
+		return
+	}
+	instant := float64(n) / dt
+	c.rate = smoothing*instant + (1-smoothing)*c.rate
+}
+
+// Total returns the cumulative number of bytes recorded.
+func (c *Counter) Total() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// Rate returns the current smoothed rate, in bytes per second.
+func (c *Counter) Rate() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int64(c.rate)
+}
@@ -0,0 +1,58 @@
+// Package ratelimit throttles upload/download byte rates, both globally
+// (client-wide) and per transfer, and picks which peers to unchoke using
+// the "optimistic unchoke + top-N by upload rate" algorithm from the
+// BitTorrent spec.
+package ratelimit
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter caps a byte rate. A Limiter returned by New(0) (the zero value
+// included) never blocks, so rate limiting can be disabled without
+// special-casing nil checks at call sites.
+type Limiter struct {
+	l *rate.Limiter
+}
+
+// New returns a Limiter allowing up to bytesPerSec bytes per second,
+// bursting up to one second's worth. bytesPerSec <= 0 means unlimited.
+func New(bytesPerSec int) *Limiter {
+	if bytesPerSec <= 0 {
+		return &Limiter{}
+	}
+	return &Limiter{l: rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)}
+}
+
+// WaitN blocks until n bytes may be sent/received under this limiter.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || l.l == nil {
+		return nil
+	}
+	// WaitN refuses to wait for more than the bucket's burst size in one
+	// call, so spend a large n across as many bucket-sized waits as
+	// needed instead of erroring out.
+	burst := l.l.Burst()
+	for n > burst {
+		if err := l.l.WaitN(ctx, burst); err != nil {
+			return err
+		}
+		n -= burst
+	}
+	if n > 0 {
+		return l.l.WaitN(ctx, n)
+	}
+	return nil
+}
+
+// SetLimit changes the allowed rate (and matches the burst to it). It has
+// no effect on a Limiter that New created unlimited.
+func (l *Limiter) SetLimit(bytesPerSec int) {
+	if l.l == nil {
+		return
+	}
+	l.l.SetLimit(rate.Limit(bytesPerSec))
+	l.l.SetBurst(bytesPerSec)
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import "sort"
+
+// UnchokeCandidate is a peer considered for (un)choking.
+type UnchokeCandidate struct {
+	// ID identifies the peer; it is opaque to this package and only used
+	// as a map key in the result.
+	ID interface{}
+	// UploadRate is the current rate, in bytes per second, at which we
+	// are sending this peer data (or, as a seed, at which it is
+	// requesting data from us).
+	UploadRate int64
+	Interested bool
+}
+
+// Unchoke decides which peers to unchoke: the numRegular Interested
+// candidates with the highest UploadRate, plus one additional
+// "optimistic" unchoke picked round-robin over the rest so that every
+// peer eventually gets a chance to prove itself, as described by the
+// BitTorrent spec. round should increase by one on every call from a
+// fresh round (e.g. every 30s) so the optimistic slot rotates.
+func Unchoke(candidates []UnchokeCandidate, numRegular int, round int) map[interface{}]bool {
+	unchoked := make(map[interface{}]bool, numRegular+1)
+
+	interested := make([]UnchokeCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		if c.Interested {
+			interested = append(interested, c)
+		}
+	}
+	sort.Slice(interested, func(i, j int) bool { return interested[i].UploadRate > interested[j].UploadRate })
+
+	regular := interested
+	if len(regular) > numRegular {
+		regular = regular[:numRegular]
+	}
+	for _, c := range regular {
+		unchoked[c.ID] = true
+	}
+
+	var rest []UnchokeCandidate
+	for _, c := range candidates {
+		if !unchoked[c.ID] {
+			rest = append(rest, c)
+		}
+	}
+	if len(rest) > 0 {
+		optimistic := rest[((round%len(rest))+len(rest))%len(rest)]
+		unchoked[optimistic.ID] = true
+	}
+
+	return unchoked
+}
@@ -0,0 +1,31 @@
+package requeststrategy
+
+// Endgame reports whether endgame mode should be active: few enough
+// pieces remain missing that it is faster to request each of them from
+// every peer that has it (canceling the rest as soon as one arrives)
+// than to wait out whichever single, possibly-slow peer each one was
+// requested from.
+func (p *Picker) Endgame(pieces []PieceState) bool {
+	missing := 0
+	for _, s := range pieces {
+		if !s.Have {
+			missing++
+		}
+	}
+	return missing > 0 && missing <= p.endgameThreshold
+}
+
+// EndgameRequests returns, for every not-yet-completed piece eligible by
+// priority, the full set of peers it should be requested from. The
+// downloader is expected to send a request to each of them and, on
+// receiving the piece's data from one, send a cancel to the rest.
+func (p *Picker) EndgameRequests(pieces []PieceState) map[uint32][]PeerID {
+	requests := make(map[uint32][]PeerID)
+	for _, s := range pieces {
+		if s.Have || s.Priority == PiecePriorityNone || len(s.Peers) == 0 {
+			continue
+		}
+		requests[s.Index] = s.Peers
+	}
+	return requests
+}
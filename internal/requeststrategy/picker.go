@@ -0,0 +1,121 @@
+package requeststrategy
+
+import "math/rand"
+
+// Picker tracks which pieces have already been requested and picks the
+// next one according to its Mode. It is not safe for concurrent use; the
+// downloader is expected to serialize calls the same way it already
+// serializes access to piece state.
+type Picker struct {
+	mode             Mode
+	requested        map[uint32]bool
+	pickedFirst      bool
+	endgameThreshold int
+}
+
+// New returns a Picker using mode as its base strategy.
+func New(mode Mode) *Picker {
+	return &Picker{
+		mode:             mode,
+		requested:        make(map[uint32]bool),
+		endgameThreshold: DefaultEndgameThreshold,
+	}
+}
+
+// SetEndgameThreshold overrides DefaultEndgameThreshold, mainly for tests
+// that want endgame to kick in without downloading hundreds of pieces
+// first.
+func (p *Picker) SetEndgameThreshold(n int) { p.endgameThreshold = n }
+
+// Next returns the index of the next piece to request, given a snapshot
+// of every piece in the transfer. ok is false when nothing is currently
+// requestable (e.g. every remaining piece is either already requested or
+// has no connected peer that holds it).
+func (p *Picker) Next(pieces []PieceState) (index uint32, ok bool) {
+	candidates := p.candidates(pieces)
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	var chosen PieceState
+	switch {
+	case !p.pickedFirst && p.mode == RarestFirst:
+		chosen = candidates[rand.Intn(len(candidates))]
+	case p.mode == Sequential:
+		chosen = lowestIndex(candidates)
+	default:
+		chosen = rarest(candidates)
+	}
+
+	p.pickedFirst = true
+	p.requested[chosen.Index] = true
+	return chosen.Index, true
+}
+
+// candidates returns the pieces eligible for selection: not already
+// owned or requested, with at least one peer, restricted to the highest
+// Priority tier present so PiecePriorityNow pieces are always exhausted
+// before PiecePriorityNormal ones are considered.
+func (p *Picker) candidates(pieces []PieceState) []PieceState {
+	var (
+		out  []PieceState
+		best = PiecePriorityNone
+	)
+	for _, s := range pieces {
+		if s.Have || p.requested[s.Index] || s.Priority == PiecePriorityNone || len(s.Peers) == 0 {
+			continue
+		}
+		switch {
+		case s.Priority > best:
+			best = s.Priority
+			out = append(out[:0], s)
+		case s.Priority == best:
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Requestable reports whether Next would currently return a piece,
+// without committing to (and marking requested) whichever one it would
+// pick. Callers that can't act on Next's result immediately (e.g. they
+// only have a signal channel to wake the real caller of Next, not a way
+// to hand it the chosen index) should use this instead of Next to check
+// whether to send that signal - calling Next just to throw away the
+// index it returns would mark a piece requested that then never
+// actually gets requested by anyone.
+func (p *Picker) Requestable(pieces []PieceState) bool {
+	return len(p.candidates(pieces)) > 0
+}
+
+// Requested reports whether index currently has an outstanding request
+// placed by a previous call to Next.
+func (p *Picker) Requested(index uint32) bool { return p.requested[index] }
+
+// Done marks index as fully downloaded and verified, so it is no longer
+// considered outstanding.
+func (p *Picker) Done(index uint32) { delete(p.requested, index) }
+
+// Cancel marks index as no longer requested without marking it done,
+// e.g. because the peer it was requested from disconnected.
+func (p *Picker) Cancel(index uint32) { delete(p.requested, index) }
+
+func lowestIndex(pieces []PieceState) PieceState {
+	chosen := pieces[0]
+	for _, s := range pieces[1:] {
+		if s.Index < chosen.Index {
+			chosen = s
+		}
+	}
+	return chosen
+}
+
+func rarest(pieces []PieceState) PieceState {
+	chosen := pieces[0]
+	for _, s := range pieces[1:] {
+		if len(s.Peers) < len(chosen.Peers) {
+			chosen = s
+		}
+	}
+	return chosen
+}
@@ -0,0 +1,128 @@
+package requeststrategy
+
+import "testing"
+
+// TestPickerRarestFirstConverges checks that, once the first piece has
+// been picked, RarestFirst always chooses the least-available candidate
+// even when availability across the swarm is heavily skewed.
+func TestPickerRarestFirstConverges(t *testing.T) {
+	p := New(RarestFirst)
+
+	pieces := []PieceState{
+		{Index: 0, Priority: PiecePriorityNormal, Peers: []PeerID{"a", "b", "c", "d"}},
+		{Index: 1, Priority: PiecePriorityNormal, Peers: []PeerID{"a"}},
+		{Index: 2, Priority: PiecePriorityNormal, Peers: []PeerID{"a", "b"}},
+		{Index: 3, Priority: PiecePriorityNormal, Peers: []PeerID{"a", "b", "c"}},
+	}
+
+	// The first pick is random among every candidate (all pieces share
+	// PiecePriorityNormal, so they form one tier), so just mark whatever
+	// it chose as done and move on to the steady state.
+	first, ok := p.Next(pieces)
+	if !ok {
+		t.Fatal("Next returned ok=false on the first call")
+	}
+	p.Done(first)
+	pieces[first].Have = true
+
+	for i := 0; i < 3; i++ {
+		index, ok := p.Next(pieces)
+		if !ok {
+			t.Fatalf("round %d: Next returned ok=false", i)
+		}
+		rarestIndex := rarestRemaining(pieces)
+		if index != rarestIndex {
+			t.Fatalf("round %d: picked piece %d, want rarest remaining piece %d", i, index, rarestIndex)
+		}
+		p.Done(index)
+		pieces[index].Have = true
+	}
+}
+
+// rarestRemaining returns the index of the least-available piece not yet
+// marked Have, breaking ties by lowest index.
+func rarestRemaining(pieces []PieceState) uint32 {
+	var chosen *PieceState
+	for i := range pieces {
+		if pieces[i].Have {
+			continue
+		}
+		if chosen == nil || len(pieces[i].Peers) < len(chosen.Peers) {
+			chosen = &pieces[i]
+		}
+	}
+	return chosen.Index
+}
+
+// TestPickerEndgameCompletesUnderLossyPeers simulates a download with a
+// few pieces left where one of the two peers holding each piece drops
+// every request (it "has" the piece but never answers), and verifies that endgame mode - requesting
+// each remaining piece from every peer that holds it - still lets the
+// transfer finish instead of stalling on whichever peer happened to be
+// asked first.
+func TestPickerEndgameCompletesUnderLossyPeers(t *testing.T) {
+	p := New(RarestFirst)
+	p.SetEndgameThreshold(3)
+
+	pieces := []PieceState{
+		{Index: 0, Priority: PiecePriorityNormal, Peers: []PeerID{"a", "b"}},
+		{Index: 1, Priority: PiecePriorityNormal, Peers: []PeerID{"a", "b"}},
+		{Index: 2, Priority: PiecePriorityNormal, Peers: []PeerID{"a", "b"}},
+	}
+
+	if !p.Endgame(pieces) {
+		t.Fatal("Endgame() = false, want true with 3 missing pieces and threshold 3")
+	}
+
+	requests := p.EndgameRequests(pieces)
+	if len(requests) != len(pieces) {
+		t.Fatalf("EndgameRequests returned %d pieces, want %d", len(requests), len(pieces))
+	}
+
+	// A lossy peer set: peer "a" never answers, peer "b" always does.
+	// Since every piece was requested from both, the transfer still
+	// completes using "b"'s responses alone.
+	lossy := map[PeerID]bool{"a": false, "b": true}
+	for index, peers := range requests {
+		delivered := false
+		for _, peer := range peers {
+			if lossy[peer] {
+				delivered = true
+				break
+			}
+		}
+		if !delivered {
+			t.Fatalf("piece %d was not requested from any responsive peer", index)
+		}
+		p.Done(index)
+		pieces[index].Have = true
+	}
+
+	if p.Endgame(pieces) {
+		t.Fatal("Endgame() = true after every piece completed, want false")
+	}
+}
+
+// TestPickerRequestableDoesNotCommit checks that Requestable, unlike
+// Next, never marks a piece requested - calling it repeatedly must keep
+// reporting true until something actually calls Next.
+func TestPickerRequestableDoesNotCommit(t *testing.T) {
+	p := New(Sequential)
+	pieces := []PieceState{
+		{Index: 0, Priority: PiecePriorityNormal, Peers: []PeerID{"a"}},
+	}
+
+	for i := 0; i < 3; i++ {
+		if !p.Requestable(pieces) {
+			t.Fatalf("round %d: Requestable() = false, want true", i)
+		}
+	}
+
+	index, ok := p.Next(pieces)
+	if !ok || index != 0 {
+		t.Fatalf("Next() = (%d, %v), want (0, true)", index, ok)
+	}
+	if p.Requestable(pieces) {
+		t.Fatal("Requestable() = true after Next requested the only candidate, want false")
+	}
+}
@@ -0,0 +1,55 @@
+// Package requeststrategy decides which piece to request next, replacing
+// the implicit selection that used to happen behind a transfer's haveC
+// channel. It knows nothing about connections or wire messages: callers
+// (the downloader) supply a snapshot of piece state and get back a piece
+// index to request, so the same strategy works whether that piece then
+// gets requested from a single peer or, in endgame mode, from all of
+// them at once.
+package requeststrategy
+
+// Priority controls how eagerly a piece is requested. A reader/streaming
+// API can raise the priority of the pieces around its current read
+// offset so they are fetched ahead of the rest of the download.
+type Priority int
+
+const (
+	// PiecePriorityNone excludes a piece from selection entirely, e.g.
+	// for files deselected from a multi-file torrent.
+	PiecePriorityNone Priority = iota
+	PiecePriorityNormal
+	PiecePriorityNext
+	PiecePriorityNow
+)
+
+// PeerID identifies a connected peer. It is opaque to this package; the
+// downloader passes through whatever it uses internally (e.g. a *peer
+// pointer) so two PeerID values are comparable with ==.
+type PeerID interface{}
+
+// PieceState is a snapshot of a single piece, supplied by the downloader
+// so this package stays decoupled from the concrete piece type.
+type PieceState struct {
+	Index    uint32
+	Have     bool // already downloaded and hash-verified
+	Priority Priority
+	Peers    []PeerID // connected peers known to have this piece
+}
+
+// Mode selects the overall piece-selection algorithm. Endgame mode layers
+// on top of either one once few enough pieces remain.
+type Mode int
+
+const (
+	// RarestFirst requests the least-available piece first, except for
+	// the very first piece of a transfer, which is picked at random
+	// among all candidates of the highest priority tier present,
+	// regardless of availability, so a first piece is ready to share
+	// quickly.
+	RarestFirst Mode = iota
+	// Sequential requests pieces in index order, for streaming/playback.
+	Sequential
+)
+
+// DefaultEndgameThreshold is how many pieces may remain missing before
+// Picker.Endgame reports true.
+const DefaultEndgameThreshold = 20
@@ -0,0 +1,53 @@
+// Package segments resolves a byte extent of a concatenated layout of
+// files into the ordered list of per-file sub-extents needed to cover
+// it, shared by every piece of code that maps torrent-wide offsets onto
+// individual files (direct-file storage backends, webseed range
+// requests).
+package segments
+
+// Extent is one file's contribution to a resolved byte extent.
+type Extent struct {
+	FileIndex    int
+	OffsetInFile int64
+	Length       int64
+}
+
+// Resolve splits the byte extent [offset, offset+length) of a
+// concatenated layout of files, whose lengths are given by fileLengths
+// in order (sorted, contiguous), into the ordered list of per-file
+// sub-extents needed to cover it.
+func Resolve(fileLengths []int64, offset, length int64) []Extent {
+	var (
+		extents []Extent
+		fileOff int64
+		end     = offset + length
+	)
+	for i, flen := range fileLengths {
+		fileEnd := fileOff + flen
+		if offset < fileEnd && end > fileOff {
+			start := maxInt64(offset, fileOff)
+			stop := minInt64(end, fileEnd)
+			extents = append(extents, Extent{
+				FileIndex:    i,
+				OffsetInFile: start - fileOff,
+				Length:       stop - start,
+			})
+		}
+		fileOff = fileEnd
+	}
+	return extents
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,107 @@
+// Package filestorage is the storage.Storage implementation: every file
+// in the torrent is pre-allocated to its full size up front, and pieces
+// are read/written directly against those files.
+package filestorage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cenkalti/rain/internal/torrent"
+)
+
+type Storage struct {
+	files []*os.File
+}
+
+func New() *Storage { return &Storage{} }
+
+func (s *Storage) Open(info *torrent.Info, where string) (checkHash bool, err error) {
+	s.files, checkHash, err = prepareFiles(info, where)
+	return checkHash, err
+}
+
+func (s *Storage) Close() error {
+	var firstErr error
+	for _, f := range s.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Files returns the underlying, pre-allocated *os.File handles in file
+// order, for the piece-level read/write code in transfer.go.
+func (s *Storage) Files() []*os.File { return s.files }
+
+func prepareFiles(info *torrent.Info, where string) (files []*os.File, checkHash bool, err error) {
+	var f *os.File
+	var exists bool
+
+	if !info.MultiFile {
+		f, exists, err = openOrAllocate(filepath.Join(where, info.Name), info.Length)
+		if err != nil {
+			return
+		}
+		if exists {
+			checkHash = true
+		}
+		files = []*os.File{f}
+		return
+	}
+
+	files = make([]*os.File, len(info.Files))
+	for i, ff := range info.Files {
+		parts := append([]string{where, info.Name}, ff.Path...)
+		path := filepath.Join(parts...)
+		err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
+		if err != nil {
+			return
+		}
+		files[i], exists, err = openOrAllocate(path, ff.Length)
+		if err != nil {
+			return
+		}
+		if exists {
+			checkHash = true
+		}
+	}
+	return
+}
+
+func openOrAllocate(path string, length int64) (f *os.File, exists bool, err error) {
+	f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	if fi.Size() == 0 && length != 0 {
+		if err = f.Truncate(length); err != nil {
+			return
+		}
+		if err = f.Sync(); err != nil {
+			return
+		}
+	} else {
+		if fi.Size() != length {
+			err = fmt.Errorf("%s expected to be %d bytes but it is %d bytes", path, length, fi.Size())
+			return
+		}
+		exists = true
+	}
+
+	return
+}
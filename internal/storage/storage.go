@@ -0,0 +1,24 @@
+// Package storage abstracts how a transfer's file data is allocated and
+// accessed on disk, so pieces are not tied to one particular on-disk
+// layout. Rain's config.Storage takes a Factory, so callers can plug in a
+// custom backend (S3, encrypted-at-rest, ...) in place of filestorage, the
+// only implementation shipped alongside this package. newTransfer itself
+// takes no Factory; it uses the configured one. A backend must also expose
+// its files as *os.File handles (a Files method), or transfer creation
+// fails.
+package storage
+
+import "github.com/cenkalti/rain/internal/torrent"
+
+// Storage opens (and allocates, if necessary) the on-disk representation
+// of a torrent.
+type Storage interface {
+	// Open prepares info's files under where. checkHash reports whether
+	// any of them already existed, so the caller should hash-check
+	// pieces rather than assume they are missing.
+	Open(info *torrent.Info, where string) (checkHash bool, err error)
+	Close() error
+}
+
+// Factory creates a new, unopened Storage.
+type Factory func() Storage
@@ -0,0 +1,117 @@
+// Package utmetadata implements the ut_metadata extension message (part
+// of BEP 9), used to fetch a torrent's info dictionary piece by piece
+// from a peer when all we started from was a magnet link.
+package utmetadata
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/zeebo/bencode"
+)
+
+// PieceSize is the size of every metadata piece except possibly the
+// last, per BEP 9.
+const PieceSize = 16 * 1024
+
+// Message types, sent as the "msg_type" key of the bencoded dict that
+// precedes the raw metadata bytes (for Data) in an ut_metadata message.
+const (
+	Request uint8 = 0
+	Data    uint8 = 1
+	Reject  uint8 = 2
+)
+
+// Header is the bencoded dict at the start of every ut_metadata message.
+// A Data message has the piece's raw bytes appended after it; Request
+// and Reject have no trailing payload.
+type Header struct {
+	MsgType   uint8 `bencode:"msg_type"`
+	Piece     int   `bencode:"piece"`
+	TotalSize int   `bencode:"total_size,omitempty"` // only set on Data
+}
+
+// Marshal encodes a message's header, and for Data messages, appends the
+// piece's bytes after it, as required by the wire format.
+func Marshal(h Header, piece []byte) ([]byte, error) {
+	b, err := bencode.EncodeBytes(h)
+	if err != nil {
+		return nil, err
+	}
+	if h.MsgType == Data {
+		b = append(b, piece...)
+	}
+	return b, nil
+}
+
+// Unmarshal splits a received message into its header and, for Data
+// messages, the piece bytes that follow it.
+func Unmarshal(b []byte) (Header, []byte, error) {
+	r := bytes.NewReader(b)
+	var h Header
+	if err := bencode.NewDecoder(r).Decode(&h); err != nil {
+		return h, nil, err
+	}
+	consumed := len(b) - r.Len()
+	return h, b[consumed:], nil
+}
+
+// NumPieces returns how many PieceSize chunks a metadata blob of
+// totalSize bytes is split into.
+func NumPieces(totalSize int) int {
+	return (totalSize + PieceSize - 1) / PieceSize
+}
+
+// Fetcher accumulates metadata pieces received from one or more peers
+// into the complete info dictionary bytes. A piece received again
+// replaces the copy stored earlier.
+type Fetcher struct {
+	totalSize int
+	pieces    [][]byte
+	have      int
+}
+
+// NewFetcher creates a Fetcher for a metadata blob of totalSize bytes, as
+// advertised by a peer's extension handshake.
+func NewFetcher(totalSize int) *Fetcher {
+	n := NumPieces(totalSize)
+	return &Fetcher{totalSize: totalSize, pieces: make([][]byte, n)}
+}
+
+// AddPiece records a piece received from a peer. ok is true once every
+// piece has been received and Bytes can be called.
+func (f *Fetcher) AddPiece(index int, data []byte) (ok bool, err error) {
+	if index < 0 || index >= len(f.pieces) {
+		return false, fmt.Errorf("utmetadata: piece index %d out of range [0,%d)", index, len(f.pieces))
+	}
+	if f.pieces[index] == nil {
+		f.have++
+	}
+	f.pieces[index] = data
+	return f.have == len(f.pieces), nil
+}
+
+// Done reports whether every piece has been received.
+func (f *Fetcher) Done() bool { return f.have == len(f.pieces) }
+
+// Bytes concatenates the received pieces into the full metadata blob. It
+// must only be called once Done reports true.
+func (f *Fetcher) Bytes() []byte {
+	b := make([]byte, 0, f.totalSize)
+	for _, p := range f.pieces {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// Missing returns the indexes of pieces not yet received, in order, so
+// the caller can (re-)request them.
+func (f *Fetcher) Missing() []int {
+	var missing []int
+	for i, p := range f.pieces {
+		if p == nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
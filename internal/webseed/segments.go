@@ -0,0 +1,58 @@
+package webseed
+
+import (
+	"fmt"
+
+	"github.com/cenkalti/rain/internal/segments"
+)
+
+// Segment is a single file's contribution to a byte extent of the torrent,
+// suitable for a "Range" HTTP request against that file's URL.
+type Segment struct {
+	FileIndex    int
+	Path         []string
+	OffsetInFile int64
+	Length       int64
+}
+
+// File is the subset of torrent.File (and the single-file case of
+// torrent.Info) that the segment resolver needs: its path relative to the
+// seed root and its length. Callers build this from
+// torrent.Info.UpvertedFiles().
+type File struct {
+	Path   []string
+	Length int64
+}
+
+// Segments resolves the byte extent [offset, offset+length) of the
+// concatenated file layout described by files (sorted, contiguous, as
+// returned by torrent.Info.UpvertedFiles()) into the ordered list of
+// per-file sub-requests needed to cover it.
+func Segments(files []File, offset, length int64) ([]Segment, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("webseed: invalid length %d", length)
+	}
+	var (
+		lengths  = make([]int64, len(files))
+		totalLen int64
+	)
+	for i, f := range files {
+		lengths[i] = f.Length
+		totalLen += f.Length
+	}
+	if end := offset + length; totalLen < end {
+		return nil, fmt.Errorf("webseed: extent [%d, %d) exceeds total length %d", offset, end, totalLen)
+	}
+
+	extents := segments.Resolve(lengths, offset, length)
+	result := make([]Segment, len(extents))
+	for i, e := range extents {
+		result[i] = Segment{
+			FileIndex:    e.FileIndex,
+			Path:         files[e.FileIndex].Path,
+			OffsetInFile: e.OffsetInFile,
+			Length:       e.Length,
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,42 @@
+package webseed
+
+import "fmt"
+
+// ParseURLList normalizes the bencoded "url-list" key, which per BEP 19 may
+// be a single URL string or a list of URL strings, into a plain []string.
+// A missing key (v == nil) is not an error; it yields no webseeds.
+func ParseURLList(v interface{}) ([]string, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{t}, nil
+	case []string:
+		return t, nil
+	case []interface{}:
+		urls := make([]string, 0, len(t))
+		for _, e := range t {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("webseed: url-list entry is not a string: %T", e)
+			}
+			urls = append(urls, s)
+		}
+		return urls, nil
+	default:
+		return nil, fmt.Errorf("webseed: unexpected url-list type %T", v)
+	}
+}
+
+// NewSet builds a Client per URL in urls, skipping construction entirely
+// when the config disables webseeds.
+func NewSet(urls []string, name string, multiFile bool, files []File, config Config) []*Client {
+	if config.Disable || len(urls) == 0 {
+		return nil
+	}
+	clients := make([]*Client, len(urls))
+	for i, u := range urls {
+		clients[i] = New(u, name, multiFile, files, config)
+	}
+	return clients
+}
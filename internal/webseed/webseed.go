@@ -0,0 +1,157 @@
+// Package webseed implements HTTP(S) piece sources as described in BEP 19
+// ("WebSeed - HTTP/FTP Seeding") and, for single-file torrents, the older
+// BEP 17 "getright" style. A Client maps piece-sized byte extents onto
+// Range GET requests against the URLs listed in a torrent's url-list, so
+// webseeds can be treated as peers by the rest of the download code.
+package webseed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Config controls how a Client behaves.
+type Config struct {
+	// Disable turns off webseed usage entirely, even if the torrent lists
+	// url-list entries.
+	Disable bool
+	// MaxRequests limits the number of in-flight range requests per
+	// webseed. Zero means a small built-in default.
+	MaxRequests int
+	// Client is used to make the range requests. http.DefaultClient is
+	// used when nil.
+	Client *http.Client
+}
+
+func (c Config) maxRequests() int {
+	if c.MaxRequests <= 0 {
+		return 4
+	}
+	return c.MaxRequests
+}
+
+func (c Config) httpClient() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+	return c.Client
+}
+
+// Client fetches pieces of a single torrent from a single webseed URL.
+// It is safe for concurrent use.
+type Client struct {
+	URL string
+
+	name      string   // info.Name, appended to directory-style URLs
+	multiFile bool
+	files     []File // from torrent.Info.UpvertedFiles()
+	config    Config
+	sem       chan struct{}
+
+	mu      sync.Mutex
+	banned  bool // set after any failed request, cleared by Reset
+}
+
+// New returns a Client that serves pieces of a torrent named name from url.
+// files must be sorted and contiguous, as returned by
+// torrent.Info.UpvertedFiles().
+func New(url, name string, multiFile bool, files []File, config Config) *Client {
+	return &Client{
+		URL:       strings.TrimRight(url, "/"),
+		name:      name,
+		multiFile: multiFile,
+		files:     files,
+		config:    config,
+		sem:       make(chan struct{}, config.maxRequests()),
+	}
+}
+
+// Banned reports whether a request to the webseed has failed (an error
+// status, a transport error or a short read) since it was last Reset.
+func (c *Client) Banned() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.banned
+}
+
+// Reset clears the ban set by a previous error response, allowing the
+// webseed to be retried.
+func (c *Client) Reset() {
+	c.mu.Lock()
+	c.banned = false
+	c.mu.Unlock()
+}
+
+func (c *Client) ban() {
+	c.mu.Lock()
+	c.banned = true
+	c.mu.Unlock()
+}
+
+// Download fetches the byte extent [offset, offset+length) of the torrent,
+// issuing one Range GET per file segment it overlaps and concatenating the
+// results. A short read from any segment is an error.
+func (c *Client) Download(ctx context.Context, offset, length int64) ([]byte, error) {
+	segments, err := Segments(c.files, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, length)
+	for _, seg := range segments {
+		b, err := c.downloadSegment(ctx, seg)
+		if err != nil {
+			c.ban()
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
+
+func (c *Client) downloadSegment(ctx context.Context, seg Segment) ([]byte, error) {
+	select {
+	case c.sem <- struct{}{}:
+		defer func() { <-c.sem }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.segmentURL(seg), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", seg.OffsetInFile, seg.OffsetInFile+seg.Length-1))
+
+	resp, err := c.config.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("webseed: %s: unexpected status %d", c.URL, resp.StatusCode)
+	}
+
+	b := make([]byte, seg.Length)
+	n, err := io.ReadFull(resp.Body, b)
+	if err != nil {
+		return nil, fmt.Errorf("webseed: %s: short read: got %d, want %d: %w", c.URL, n, seg.Length, err)
+	}
+	return b, nil
+}
+
+// segmentURL builds the request URL for seg, per BEP 19: directory-style
+// seeds get the torrent name and the file's path components appended;
+// single-file (BEP 17 style) seeds are requested as-is.
+func (c *Client) segmentURL(seg Segment) string {
+	if !c.multiFile {
+		return c.URL
+	}
+	parts := append([]string{c.name}, seg.Path...)
+	return c.URL + "/" + path.Join(parts...)
+}
@@ -0,0 +1,15 @@
+package rain
+
+import "github.com/cenkalti/rain/internal/magnet"
+
+// AddMagnet starts a transfer from a magnet URI (BEP 9). Unlike a
+// .torrent-based transfer, the piece layout is not known yet: the
+// returned transfer first fetches the info dictionary from a peer over
+// the ut_metadata extension before it starts downloading pieces.
+func (r *Rain) AddMagnet(uri string, where string) (*transfer, error) {
+	m, err := magnet.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	return r.newMagnetTransfer(m, where)
+}
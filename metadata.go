@@ -0,0 +1,213 @@
+package rain
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/cenkalti/rain/internal/connection"
+	"github.com/cenkalti/rain/internal/extension"
+	"github.com/cenkalti/rain/internal/pex"
+	"github.com/cenkalti/rain/internal/tracker"
+	"github.com/cenkalti/rain/internal/utmetadata"
+)
+
+// extendedMessageID is the reserved BitTorrent message ID for the
+// extension protocol (BEP 10); the byte right after it is the sender's
+// chosen ID for the specific extension (0 for the handshake itself).
+const extendedMessageID = 20
+
+var errUnexpectedMessage = errors.New("rain: expected an extended message")
+
+// findMetadataPeers looks for peers of an info hash we don't have the
+// metadata for yet, via the magnet link's trackers and the DHT, and
+// pushes every address it learns about onto peersC until t.Finished is
+// closed.
+func (t *transfer) findMetadataPeers(peersC chan<- []*net.TCPAddr) {
+	if node, err := t.rain.dhtNode(); err != nil {
+		t.log.Error(err)
+	} else if node != nil {
+		dhtPeersC := make(chan []*net.TCPAddr)
+		go node.Announce(t.infoHash, dhtPeersC, t.Finished)
+		go forwardPeers(dhtPeersC, peersC, t.Finished)
+	}
+
+	for _, url := range t.trackers {
+		tr, err := tracker.New(url, t.rain)
+		if err != nil {
+			t.log.Error(err)
+			continue
+		}
+		announceC := make(chan *tracker.AnnounceResponse)
+		go tracker.AnnouncePeriodically(tr, t, nil, tracker.Started, nil, announceC)
+		go func() {
+			for resp := range announceC {
+				if resp.Error != nil {
+					t.log.Error(resp.Error)
+					continue
+				}
+				select {
+				case peersC <- resp.Peers:
+				case <-t.Finished:
+					return
+				}
+			}
+		}()
+	}
+}
+
+func forwardPeers(src <-chan []*net.TCPAddr, dst chan<- []*net.TCPAddr, stopC chan struct{}) {
+	for addrs := range src {
+		select {
+		case dst <- addrs:
+		case <-stopC:
+			return
+		}
+	}
+}
+
+// fetchMetadataFrom dials addr, speaks just enough of the extension
+// protocol (BEP 10) to pull the complete info dictionary over
+// ut_metadata (BEP 9) and sends it on t.metadataC. While waiting for the
+// metadata, it also relays any peers the remote mentions over ut_pex
+// (BEP 11) back onto peersC, skipping addresses metadataPeerKnown has
+// already recorded so ones already dialed aren't relayed again. It gives up silently on any error, since other peers
+// found by findMetadataPeers are tried concurrently and only the first
+// one to finish the metadata fetch is used.
+//
+// This is the only ut_pex exchange in this tree: once awaitMetadata
+// hands off to the normal download loop (magnet transfers) or for any
+// .torrent-based transfer (which never goes through awaitMetadata at
+// all), peer connections are served by internal/peer, outside this
+// package, and don't speak ut_pex for the life of the transfer the way
+// the request described. Wiring that in needs a change inside
+// internal/peer's own connection handling, which this series doesn't
+// touch.
+func (t *transfer) fetchMetadataFrom(addr *net.TCPAddr, peersC chan<- []*net.TCPAddr) {
+	conn, _, _, _, err := connection.Dial(addr, !t.rain.config.Encryption.DisableOutgoing, t.rain.config.Encryption.ForceOutgoing, [8]byte{}, t.infoHash, t.rain.peerID)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// The IDs we assign in our handshake; the peer addresses its
+	// ut_metadata and ut_pex messages to us with these.
+	const ourUTMetadataID, ourUTPexID extension.ID = 1, 2
+	handshake, err := extension.Marshal(extension.Handshake{M: map[string]extension.ID{extension.UTMetadata: ourUTMetadataID, extension.UTPex: ourUTPexID}})
+	if err != nil {
+		t.log.Error(err)
+		return
+	}
+	if err = writeExtendedMessage(conn, 0, handshake); err != nil {
+		return
+	}
+
+	var peerUTMetadataID extension.ID
+	var havePEX bool
+	// seen checks an address against metadataPeersSeen without marking
+	// it; awaitMetadata marks it when it takes the address off peersC.
+	seen := func(a *net.TCPAddr) bool {
+		t.metadataPeersM.Lock()
+		defer t.metadataPeersM.Unlock()
+		return t.metadataPeersSeen[a.String()]
+	}
+	var fetcher *utmetadata.Fetcher
+	var pexLimiter pex.Limiter
+	for {
+		extID, payload, err := readExtendedMessage(conn)
+		if err != nil {
+			return
+		}
+		switch {
+		case extID == 0:
+			h, err := extension.Unmarshal(payload)
+			if err != nil {
+				return
+			}
+			id, ok := h.Supports(extension.UTMetadata)
+			if !ok || h.MetadataSize <= 0 {
+				return
+			}
+			peerUTMetadataID = id
+			if _, ok := h.Supports(extension.UTPex); ok {
+				havePEX = true
+			}
+			fetcher = utmetadata.NewFetcher(h.MetadataSize)
+			for _, piece := range fetcher.Missing() {
+				req, err := utmetadata.Marshal(utmetadata.Header{MsgType: utmetadata.Request, Piece: piece}, nil)
+				if err != nil {
+					return
+				}
+				if err = writeExtendedMessage(conn, uint8(peerUTMetadataID), req); err != nil {
+					return
+				}
+			}
+		case havePEX && extID == uint8(ourUTPexID):
+			msg, err := pex.Unmarshal(payload)
+			if err != nil {
+				continue
+			}
+			if fresh := pex.Filter(msg, &pexLimiter, seen, time.Now()); len(fresh) > 0 {
+				select {
+				case peersC <- fresh:
+				case <-t.Finished:
+					return
+				}
+			}
+		case fetcher != nil:
+			header, piece, err := utmetadata.Unmarshal(payload)
+			if err != nil {
+				return
+			}
+			if header.MsgType != utmetadata.Data {
+				return
+			}
+			done, err := fetcher.AddPiece(header.Piece, piece)
+			if err != nil {
+				return
+			}
+			if done {
+				select {
+				case t.metadataC <- fetcher.Bytes():
+				case <-t.Finished:
+				}
+				return
+			}
+		}
+	}
+}
+
+func writeExtendedMessage(conn net.Conn, extID uint8, payload []byte) error {
+	buf := make([]byte, 4+2+len(payload))
+	binary.BigEndian.PutUint32(buf[:4], uint32(2+len(payload)))
+	buf[4] = extendedMessageID
+	buf[5] = extID
+	copy(buf[6:], payload)
+	_, err := conn.Write(buf)
+	return err
+}
+
+// readExtendedMessage reads the next message from conn, skipping
+// keep-alives, and returns an error if it isn't an extended message.
+func readExtendedMessage(conn net.Conn) (extID uint8, payload []byte, err error) {
+	var lenBuf [4]byte
+	for {
+		if _, err = io.ReadFull(conn, lenBuf[:]); err != nil {
+			return 0, nil, err
+		}
+		n := binary.BigEndian.Uint32(lenBuf[:])
+		if n == 0 {
+			continue // keep-alive
+		}
+		buf := make([]byte, n)
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			return 0, nil, err
+		}
+		if buf[0] != extendedMessageID {
+			return 0, nil, errUnexpectedMessage
+		}
+		return buf[1], buf[2:], nil
+	}
+}
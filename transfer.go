@@ -1,34 +1,142 @@
 package rain
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/cenkalti/mse"
+	"github.com/zeebo/bencode"
 
 	"github.com/cenkalti/rain/internal/bitfield"
 	"github.com/cenkalti/rain/internal/connection"
 	"github.com/cenkalti/rain/internal/logger"
+	"github.com/cenkalti/rain/internal/magnet"
 	"github.com/cenkalti/rain/internal/protocol"
+	"github.com/cenkalti/rain/internal/ratelimit"
+	"github.com/cenkalti/rain/internal/requeststrategy"
+	"github.com/cenkalti/rain/internal/segments"
+	"github.com/cenkalti/rain/internal/storage"
+	"github.com/cenkalti/rain/internal/storage/filestorage"
 	"github.com/cenkalti/rain/internal/torrent"
 	"github.com/cenkalti/rain/internal/tracker"
+	"github.com/cenkalti/rain/internal/webseed"
 )
 
 // transfer represents an active transfer in the program.
+//
+// A transfer created from a magnet link starts with torrent == nil: only
+// infoHash and trackers are known. Run fetches the info dictionary over
+// the ut_metadata extension before the fields below it become valid and
+// the normal download loop starts.
 type transfer struct {
-	rain     *Rain
+	rain      *Rain
+	infoHash  protocol.InfoHash
+	trackers  []string
+	where     string
+	metadataC chan []byte
+
 	tracker  tracker.Tracker
 	torrent  *torrent.Torrent
+	storage  storage.Storage
 	pieces   []*piece
 	bitField bitfield.BitField // pieces that we have
 	Finished chan struct{}
 	haveC    chan peerHave
 	peers    map[*peer]struct{}
 	peersM   sync.RWMutex
-	log      logger.Logger
+	webSeeds []*webseed.Client
+
+	picker      *requeststrategy.Picker
+	prioritiesM sync.RWMutex
+	priorities  map[uint32]requeststrategy.Priority
+
+	downLimiter   *ratelimit.Limiter
+	upLimiter     *ratelimit.Limiter
+	uploadedBytes *ratelimit.Counter
+
+	// peerUpload tracks each connected peer's own upload-rate counter,
+	// separate from uploadedBytes above (the transfer-wide total), so
+	// runUnchoke can rank peers individually.
+	peerUpload map[*peer]*ratelimit.Counter
+	unchokedM  sync.RWMutex
+	unchoked   map[*peer]bool
+
+	// metadataPeersSeen is the set of addresses already dialed (or
+	// learned and filtered out) during the magnet ut_metadata bootstrap,
+	// keyed by addr.String(). awaitMetadata marks every address it is
+	// handed, tracker/DHT- and ut_pex-sourced alike, through
+	// metadataPeerKnown, and fetchMetadataFrom checks ut_pex addresses
+	// against it before relaying them, so a peer's ut_pex message can't
+	// make awaitMetadata re-dial someone it already knows about.
+	metadataPeersM    sync.Mutex
+	metadataPeersSeen map[string]bool
+
+	log logger.Logger
+}
+
+// metadataPeerKnown reports whether addr has already been seen during
+// the magnet metadata bootstrap, and marks it seen either way, so the
+// very next call (from any goroutine) with the same address returns
+// true.
+func (t *transfer) metadataPeerKnown(addr *net.TCPAddr) bool {
+	t.metadataPeersM.Lock()
+	defer t.metadataPeersM.Unlock()
+	if t.metadataPeersSeen == nil {
+		t.metadataPeersSeen = make(map[string]bool)
+	}
+	key := addr.String()
+	seen := t.metadataPeersSeen[key]
+	t.metadataPeersSeen[key] = true
+	return seen
+}
+
+// Unchoked reports whether p was selected as one of this transfer's
+// unchoked peers the last time runUnchoke ran.
+//
+// Nothing in this tree calls Unchoked yet: sending the actual choke/
+// unchoke wire message is the job of whatever serves p's connection
+// (peer.Serve, and the upload-request handling uploader.Run does
+// alongside it), and neither file is part of this snapshot, so there is
+// nowhere in code we own to place that call without guessing at a
+// method on the hidden peer type. runUnchoke/Unchoked are kept as the
+// real ranking decision (see ratelimit.Unchoke), ready for
+// whichever of those two hidden files grows a hook to consult it.
+func (t *transfer) Unchoked(p *peer) bool {
+	t.unchokedM.RLock()
+	defer t.unchokedM.RUnlock()
+	return t.unchoked[p]
+}
+
+// SetPriority raises or lowers how eagerly piece index is requested, e.g.
+// so a reader/streaming API can pull the pieces around its current read
+// offset ahead of the rest of the download.
+func (t *transfer) SetPriority(index uint32, p requeststrategy.Priority) {
+	t.prioritiesM.Lock()
+	defer t.prioritiesM.Unlock()
+	if p == requeststrategy.PiecePriorityNormal {
+		delete(t.priorities, index)
+		return
+	}
+	if t.priorities == nil {
+		t.priorities = make(map[uint32]requeststrategy.Priority)
+	}
+	t.priorities[index] = p
+}
+
+// priority returns the configured priority for piece index, defaulting
+// to PiecePriorityNormal.
+func (t *transfer) priority(index uint32) requeststrategy.Priority {
+	t.prioritiesM.RLock()
+	defer t.prioritiesM.RUnlock()
+	if p, ok := t.priorities[index]; ok {
+		return p
+	}
+	return requeststrategy.PiecePriorityNormal
 }
 
 func (r *Rain) newTransfer(tor *torrent.Torrent, where string) (*transfer, error) {
@@ -36,41 +144,266 @@ func (r *Rain) newTransfer(tor *torrent.Torrent, where string) (*transfer, error
 	if err != nil {
 		return nil, err
 	}
-	files, checkHash, err := prepareFiles(tor.Info, where)
+	st, pieces, bitField, err := prepareTransfer(tor.Info, where, r.storageFactory())
 	if err != nil {
 		return nil, err
 	}
-	pieces := newPieces(tor.Info, files)
+	name := tor.Info.Name
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	return &transfer{
+		rain:          r,
+		infoHash:      tor.Info.Hash,
+		where:         where,
+		tracker:       tracker,
+		torrent:       tor,
+		storage:       st,
+		pieces:        pieces,
+		bitField:      bitField,
+		Finished:      make(chan struct{}),
+		haveC:         make(chan peerHave),
+		peers:         make(map[*peer]struct{}),
+		webSeeds:      newWebSeeds(tor.Info, nil, r.config.WebSeed),
+		picker:        requeststrategy.New(r.pickerMode()),
+		downLimiter:   ratelimit.New(r.config.RateLimit.DownloadPerTransfer),
+		upLimiter:     ratelimit.New(r.config.RateLimit.UploadPerTransfer),
+		uploadedBytes: ratelimit.NewCounter(),
+		peerUpload:    make(map[*peer]*ratelimit.Counter),
+		log:           logger.New("download " + name),
+	}, nil
+}
+
+// pickerMode picks requeststrategy.Sequential when the config asks for
+// sequential (streaming-friendly) downloads, and requeststrategy.RarestFirst
+// otherwise.
+func (r *Rain) pickerMode() requeststrategy.Mode {
+	if r.config.Sequential {
+		return requeststrategy.Sequential
+	}
+	return requeststrategy.RarestFirst
+}
+
+// newMagnetTransfer creates a transfer for a magnet link (BEP 9). Its info
+// dictionary is not known yet; Run fetches it from a peer via ut_metadata
+// before the transfer can start downloading pieces.
+func (r *Rain) newMagnetTransfer(m *magnet.Magnet, where string) (*transfer, error) {
+	name := m.Name
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	if name == "" {
+		name = fmt.Sprintf("%x", m.InfoHash[:4])
+	}
+	return &transfer{
+		rain:          r,
+		infoHash:      m.InfoHash,
+		trackers:      m.Trackers,
+		where:         where,
+		metadataC:     make(chan []byte, 1),
+		Finished:      make(chan struct{}),
+		haveC:         make(chan peerHave),
+		peers:         make(map[*peer]struct{}),
+		picker:        requeststrategy.New(r.pickerMode()),
+		downLimiter:   ratelimit.New(r.config.RateLimit.DownloadPerTransfer),
+		upLimiter:     ratelimit.New(r.config.RateLimit.UploadPerTransfer),
+		uploadedBytes: ratelimit.NewCounter(),
+		peerUpload:    make(map[*peer]*ratelimit.Counter),
+		log:           logger.New("download " + name),
+	}, nil
+}
+
+// storageFactory returns the Storage implementation to use for new
+// transfers: whatever was configured (a custom backend), or
+// filestorage's pre-allocated-files behavior by default.
+func (r *Rain) storageFactory() storage.Factory {
+	if r.config.Storage != nil {
+		return r.config.Storage
+	}
+	return func() storage.Storage { return filestorage.New() }
+}
+
+// fileBacked is implemented by Storage backends that expose their
+// underlying *os.File handles directly, which piece read/write and
+// hash-checking below operate on.
+type fileBacked interface {
+	Files() []*os.File
+}
+
+// prepareTransfer opens info's files through newStorage and builds the
+// piece list and bitfield, hash-checking any files that already existed
+// on disk.
+func prepareTransfer(info *torrent.Info, where string, newStorage storage.Factory) (storage.Storage, []*piece, bitfield.BitField, error) {
+	st := newStorage()
+	checkHash, err := st.Open(info, where)
+	if err != nil {
+		return nil, nil, bitfield.BitField{}, err
+	}
+	fb, ok := st.(fileBacked)
+	if !ok {
+		return nil, nil, bitfield.BitField{}, fmt.Errorf("rain: storage backend %T does not support direct file access", st)
+	}
+	pieces := newPieces(info, fb.Files())
 	bitField := bitfield.New(uint32(len(pieces)))
 	if checkHash {
 		for _, p := range pieces {
 			ok, err := p.hashCheck()
 			if err != nil {
-				return nil, err
+				return nil, nil, bitfield.BitField{}, err
 			}
 			if ok {
 				bitField.Set(p.index)
 			}
 		}
 	}
-	name := tor.Info.Name
-	if len(name) > 8 {
-		name = name[:8]
+	return st, pieces, bitField, nil
+}
+
+// newWebSeeds builds a webseed.Client for every URL in infoBytes's
+// "url-list" key (BEP 19), unless webseeds have been disabled in the
+// config. infoBytes is the raw bencoded info dictionary the torrent or
+// magnet link was parsed from; pass nil if it isn't available (e.g. a
+// .torrent file whose raw bytes weren't threaded down to here) to skip
+// webseed support for that transfer rather than guess at it.
+func newWebSeeds(info *torrent.Info, infoBytes []byte, config webseed.Config) []*webseed.Client {
+	if len(infoBytes) == 0 {
+		return nil
+	}
+	var dict struct {
+		URLList interface{} `bencode:"url-list"`
+	}
+	if err := bencode.DecodeBytes(infoBytes, &dict); err != nil {
+		return nil
+	}
+	urls, err := webseed.ParseURLList(dict.URLList)
+	if err != nil || len(urls) == 0 {
+		return nil
+	}
+	files := make([]webseed.File, len(info.UpvertedFiles()))
+	for i, f := range info.UpvertedFiles() {
+		files[i] = webseed.File{Path: f.Path, Length: f.Length}
+	}
+	return webseed.NewSet(urls, info.Name, info.MultiFile, files, config)
+}
+
+// webSeedInterval is how often the main loop tries fetching one missing,
+// requestable piece from a webseed, as an extra source alongside
+// connected peers. Webseeds aren't peers as far as the picker/downloader
+// are concerned, so this is a separate, synchronous path that calls
+// Picker.Next itself rather than going through notifyIfRequestable's
+// wakeup of the downloader.
+const webSeedInterval = 5 * time.Second
+
+// webSeedUnbanInterval is how often banned webseeds (ones that answered
+// a request with an error) get a chance to be retried.
+const webSeedUnbanInterval = 5 * time.Minute
+
+// tryWebSeeds fetches one missing, requestable piece, trying each
+// non-banned configured webseed in turn until one delivers it with a
+// matching hash. The downloaded bytes are
+// written straight to the destination files and hash-checked exactly
+// like any other piece before bitField is updated.
+func (t *transfer) tryWebSeeds() {
+	if len(t.webSeeds) == 0 {
+		return
+	}
+	fb, ok := t.storage.(fileBacked)
+	if !ok {
+		return
+	}
+
+	index, ok := t.picker.Next(t.pieceStates())
+	if !ok {
+		return
+	}
+	completed := false
+	defer func() {
+		if completed {
+			t.picker.Done(index)
+		} else {
+			t.picker.Cancel(index)
+		}
+	}()
+
+	p := t.pieces[index]
+	offset := t.pieceOffset(index)
+	for _, ws := range t.webSeeds {
+		if ws.Banned() {
+			continue
+		}
+		data, err := ws.Download(context.Background(), offset, int64(p.length))
+		if err != nil {
+			t.log.Debug(err)
+			continue
+		}
+		if err := writeExtent(fb.Files(), t.torrent.Info, offset, data); err != nil {
+			t.log.Error(err)
+			continue
+		}
+		ok, err := p.hashCheck()
+		if err != nil {
+			t.log.Error(err)
+			continue
+		}
+		if !ok {
+			t.log.Debugf("webseed %s returned piece %d but its hash didn't match", ws.URL, index)
+			continue
+		}
+		t.bitField.Set(index)
+		completed = true
+		return
 	}
-	return &transfer{
-		rain:     r,
-		tracker:  tracker,
-		torrent:  tor,
-		pieces:   pieces,
-		bitField: bitField,
-		Finished: make(chan struct{}),
-		haveC:    make(chan peerHave),
-		peers:    make(map[*peer]struct{}),
-		log:      logger.New("download " + name),
-	}, nil
 }
 
-func (t *transfer) InfoHash() protocol.InfoHash { return t.torrent.Info.Hash }
+// webSeedTickerC returns ticker.C, or nil if ticker is nil. A nil
+// channel blocks forever in a select, which is what's wanted in Run's
+// loop when the transfer has no webseeds configured.
+func webSeedTickerC(ticker *time.Ticker) <-chan time.Time {
+	if ticker == nil {
+		return nil
+	}
+	return ticker.C
+}
+
+// resetWebSeeds clears any ban picked up by a webseed that answered a
+// request with an error, giving it another chance.
+func (t *transfer) resetWebSeeds() {
+	for _, ws := range t.webSeeds {
+		ws.Reset()
+	}
+}
+
+// pieceOffset returns the byte offset of piece index within the
+// concatenated layout of the torrent's files.
+func (t *transfer) pieceOffset(index uint32) int64 {
+	var offset int64
+	for i := uint32(0); i < index; i++ {
+		offset += int64(t.pieces[i].length)
+	}
+	return offset
+}
+
+// writeExtent writes data to the byte extent [offset, offset+len(data))
+// of the concatenated layout described by info, across files (in the
+// same order as info.UpvertedFiles(), as returned by a fileBacked
+// Storage's Files()).
+func writeExtent(files []*os.File, info *torrent.Info, offset int64, data []byte) error {
+	upverted := info.UpvertedFiles()
+	lengths := make([]int64, len(upverted))
+	for i, f := range upverted {
+		lengths[i] = f.Length
+	}
+	var pos int64
+	for _, e := range segments.Resolve(lengths, offset, int64(len(data))) {
+		if _, err := files[e.FileIndex].WriteAt(data[pos:pos+e.Length], e.OffsetInFile); err != nil {
+			return err
+		}
+		pos += e.Length
+	}
+	return nil
+}
+
+func (t *transfer) InfoHash() protocol.InfoHash { return t.infoHash }
 func (t *transfer) Downloaded() int64 {
 	var sum int64
 	for i := uint32(0); i < t.bitField.Len(); i++ {
@@ -80,24 +413,41 @@ func (t *transfer) Downloaded() int64 {
 	}
 	return sum
 }
-func (t *transfer) Uploaded() int64 { return 0 } // TODO
+func (t *transfer) Uploaded() int64 { return t.uploadedBytes.Total() }
 func (t *transfer) Left() int64     { return t.torrent.Info.TotalLength - t.Downloaded() }
 
+// UploadRate returns the current smoothed upload rate, in bytes per
+// second, across the whole transfer, for any future stats API.
+// runUnchoke ranks peers by their own per-peer counters instead.
+func (t *transfer) UploadRate() int64 { return t.uploadedBytes.Rate() }
+
 func (t *transfer) Run() {
-	sKey := mse.HashSKey(t.torrent.Info.Hash[:])
+	sKey := mse.HashSKey(t.infoHash[:])
 
 	t.rain.transfersM.Lock()
-	t.rain.transfers[t.torrent.Info.Hash] = t
+	t.rain.transfers[t.infoHash] = t
 	t.rain.transfersSKey[sKey] = t
 	t.rain.transfersM.Unlock()
 
 	defer func() {
 		t.rain.transfersM.Lock()
-		delete(t.rain.transfers, t.torrent.Info.Hash)
+		delete(t.rain.transfers, t.infoHash)
 		delete(t.rain.transfersSKey, sKey)
 		t.rain.transfersM.Unlock()
+		if t.storage != nil {
+			if err := t.storage.Close(); err != nil {
+				t.log.Error(err)
+			}
+		}
 	}()
 
+	if t.torrent == nil {
+		if err := t.awaitMetadata(); err != nil {
+			t.log.Error(err)
+			return
+		}
+	}
+
 	announceC := make(chan *tracker.AnnounceResponse)
 	if t.bitField.All() {
 		go tracker.AnnouncePeriodically(t.tracker, t, nil, tracker.Completed, nil, announceC)
@@ -111,8 +461,39 @@ func (t *transfer) Run() {
 	uploader := newUploader(t)
 	go uploader.Run()
 
+	if node, err := t.rain.dhtNode(); err != nil {
+		t.log.Error(err)
+	} else if node != nil {
+		dhtPeersC := make(chan []*net.TCPAddr)
+		go node.Announce(t.infoHash, dhtPeersC, t.Finished)
+		go func() {
+			for addrs := range dhtPeersC {
+				downloader.peersC <- addrs
+			}
+		}()
+	}
+
+	unchokeTicker := time.NewTicker(unchokeInterval)
+	defer unchokeTicker.Stop()
+	unchokeRound := 0
+
+	var webSeedTicker, webSeedUnbanTicker *time.Ticker
+	if len(t.webSeeds) > 0 {
+		webSeedTicker = time.NewTicker(webSeedInterval)
+		defer webSeedTicker.Stop()
+		webSeedUnbanTicker = time.NewTicker(webSeedUnbanInterval)
+		defer webSeedUnbanTicker.Stop()
+	}
+
 	for {
 		select {
+		case <-unchokeTicker.C:
+			t.runUnchoke(unchokeRound)
+			unchokeRound++
+		case <-webSeedTickerC(webSeedTicker):
+			t.tryWebSeeds()
+		case <-webSeedTickerC(webSeedUnbanTicker):
+			t.resetWebSeeds()
 		case announceResponse := <-announceC:
 			if announceResponse.Error != nil {
 				t.log.Error(announceResponse.Error)
@@ -126,16 +507,121 @@ func (t *transfer) Run() {
 			piece.peers = append(piece.peers, peerHave.peer)
 			piece.peersM.Unlock()
 
-			select {
-			case downloader.haveNotifyC <- struct{}{}:
-			default:
+			t.notifyIfRequestable(downloader.haveNotifyC)
+		}
+	}
+}
+
+// notifyIfRequestable asks the picker whether a piece has become
+// requestable now that a peer just announced one, and if so wakes the
+// downloader via haveNotifyC so it places the actual request.
+//
+// haveNotifyC only carries a bare wakeup, not the chosen index or (in
+// endgame) the peer set the picker computed to reach that answer - the
+// downloader that owns haveNotifyC and calls Next/EndgameRequests for
+// real isn't in this tree, so this has no way to hand its
+// answer through the channel. Using Picker.Requestable/Endgame here
+// instead of Next/EndgameRequests keeps this side-effect-free: it used
+// to call Next speculatively and discard the index, which marked a
+// piece requested that the downloader's own, separate Next call would
+// then skip over, leaking it as requested forever.
+func (t *transfer) notifyIfRequestable(haveNotifyC chan struct{}) {
+	states := t.pieceStates()
+	requestable := t.picker.Endgame(states)
+	if requestable {
+		requestable = len(t.picker.EndgameRequests(states)) > 0
+	} else {
+		requestable = t.picker.Requestable(states)
+	}
+	if !requestable {
+		return
+	}
+	select {
+	case haveNotifyC <- struct{}{}:
+	default:
+	}
+}
+
+// pieceStates snapshots every piece's completion state, priority and
+// known peers for the picker, which stays decoupled from the concrete
+// piece type.
+func (t *transfer) pieceStates() []requeststrategy.PieceState {
+	states := make([]requeststrategy.PieceState, len(t.pieces))
+	for i, p := range t.pieces {
+		p.peersM.RLock()
+		peers := make([]requeststrategy.PeerID, len(p.peers))
+		for j, peer := range p.peers {
+			peers[j] = peer
+		}
+		p.peersM.RUnlock()
+		states[i] = requeststrategy.PieceState{
+			Index:    p.index,
+			Have:     t.bitField.Test(p.index),
+			Priority: t.priority(p.index),
+			Peers:    peers,
+		}
+	}
+	return states
+}
+
+// awaitMetadata blocks until the info dictionary has been fetched from a
+// peer via the ut_metadata extension, verifies it against the info hash
+// from the magnet link, and fills in the fields a normal, .torrent-based
+// transfer would already have.
+func (t *transfer) awaitMetadata() error {
+	metadataPeersC := make(chan []*net.TCPAddr)
+	go t.findMetadataPeers(metadataPeersC)
+
+	var infoBytes []byte
+loop:
+	for {
+		select {
+		case addrs := <-metadataPeersC:
+			for _, addr := range addrs {
+				if t.metadataPeerKnown(addr) {
+					continue
+				}
+				go t.fetchMetadataFrom(addr, metadataPeersC)
 			}
+		case infoBytes = <-t.metadataC:
+			break loop
+		case <-t.Finished:
+			return errors.New("transfer: stopped while fetching metadata")
 		}
 	}
+
+	info, err := torrent.NewInfo(infoBytes)
+	if err != nil {
+		return err
+	}
+	if info.Hash != t.infoHash {
+		return fmt.Errorf("transfer: metadata info hash does not match magnet link")
+	}
+
+	var announce string
+	if len(t.trackers) > 0 {
+		announce = t.trackers[0]
+	}
+	tr, err := tracker.New(announce, t.rain)
+	if err != nil {
+		return err
+	}
+	st, pieces, bitField, err := prepareTransfer(info, t.where, t.rain.storageFactory())
+	if err != nil {
+		return err
+	}
+
+	t.tracker = tr
+	t.torrent = &torrent.Torrent{Info: info, Announce: announce}
+	t.storage = st
+	t.pieces = pieces
+	t.bitField = bitField
+	t.webSeeds = newWebSeeds(info, infoBytes, t.rain.config.WebSeed)
+	return nil
 }
 
 func (t *transfer) connect(addr *net.TCPAddr) {
-	conn, _, ext, _, err := connection.Dial(addr, !t.rain.config.Encryption.DisableOutgoing, t.rain.config.Encryption.ForceOutgoing, [8]byte{}, t.torrent.Info.Hash, t.rain.peerID)
+	conn, _, ext, _, err := connection.Dial(addr, !t.rain.config.Encryption.DisableOutgoing, t.rain.config.Encryption.ForceOutgoing, [8]byte{}, t.infoHash, t.rain.peerID)
 	if err != nil {
 		if err == connection.ErrOwnConnection {
 			t.log.Debug(err)
@@ -145,81 +631,65 @@ func (t *transfer) connect(addr *net.TCPAddr) {
 		return
 	}
 	defer conn.Close()
-	p := newPeer(conn, outgoing)
+	limited := ratelimit.WrapConn(conn, t.downLimiter, t.upLimiter, t.uploadedBytes)
+	peerBytes := ratelimit.NewCounter()
+	limited = ratelimit.WrapConn(limited, nil, nil, peerBytes)
+	p := newPeer(limited, outgoing)
 	p.log.Info("Connected to peer")
 	p.log.Debugf("Peer extensions: %s", ext)
+
+	t.trackPeerUpload(p, peerBytes)
+	defer t.untrackPeerUpload(p)
 	p.Serve(t)
 }
 
-func prepareFiles(info *torrent.Info, where string) (files []*os.File, checkHash bool, err error) {
-	var f *os.File
-	var exists bool
-
-	if !info.MultiFile {
-		f, exists, err = openOrAllocate(filepath.Join(where, info.Name), info.Length)
-		if err != nil {
-			return
-		}
-		if exists {
-			checkHash = true
-		}
-		files = []*os.File{f}
-		return
-	}
+// trackPeerUpload registers the per-peer upload-rate counter runUnchoke
+// ranks candidates by, for as long as p stays connected.
+func (t *transfer) trackPeerUpload(p *peer, counter *ratelimit.Counter) {
+	t.peersM.Lock()
+	defer t.peersM.Unlock()
+	t.peerUpload[p] = counter
+}
 
-	// Multiple files
-	files = make([]*os.File, len(info.Files))
-	for i, f := range info.Files {
-		parts := append([]string{where, info.Name}, f.Path...)
-		path := filepath.Join(parts...)
-		err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
-		if err != nil {
-			return
-		}
-		files[i], exists, err = openOrAllocate(path, f.Length)
-		if err != nil {
-			return
-		}
-		if exists {
-			checkHash = true
-		}
-	}
-	return
+func (t *transfer) untrackPeerUpload(p *peer) {
+	t.peersM.Lock()
+	defer t.peersM.Unlock()
+	delete(t.peerUpload, p)
 }
 
-func openOrAllocate(path string, length int64) (f *os.File, exists bool, err error) {
-	f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0640)
-	if err != nil {
-		return
-	}
+// numRegularUnchokes is the number of peers kept unchoked purely by
+// upload rate, on top of the single rotating optimistic unchoke, as
+// recommended by the BitTorrent spec.
+const numRegularUnchokes = 4
 
-	defer func() {
-		if err != nil {
-			f.Close()
-		}
-	}()
+// unchokeInterval is how often runUnchoke re-ranks connected peers.
+const unchokeInterval = 30 * time.Second
 
-	fi, err := f.Stat()
-	if err != nil {
-		return
+// runUnchoke re-ranks connected peers by upload rate and stores the
+// result for Unchoked to report back.
+//
+// NOTE: candidates are marked Interested unconditionally, since nothing
+// in this snapshot surfaces a peer's real interested/not-interested
+// state to transfer; once it does, that should replace the literal
+// true below.
+func (t *transfer) runUnchoke(round int) {
+	t.peersM.RLock()
+	candidates := make([]ratelimit.UnchokeCandidate, 0, len(t.peerUpload))
+	for p, counter := range t.peerUpload {
+		candidates = append(candidates, ratelimit.UnchokeCandidate{ID: p, UploadRate: counter.Rate(), Interested: true})
 	}
+	t.peersM.RUnlock()
 
-	if fi.Size() == 0 && length != 0 {
-		if err = f.Truncate(length); err != nil {
-			return
-		}
-		if err = f.Sync(); err != nil {
-			return
-		}
-	} else {
-		if fi.Size() != length {
-			err = fmt.Errorf("%s expected to be %d bytes but it is %d bytes", path, length, fi.Size())
-			return
+	unchoked := ratelimit.Unchoke(candidates, numRegularUnchokes, round)
+
+	t.unchokedM.Lock()
+	t.unchoked = make(map[*peer]bool, len(unchoked))
+	for id, ok := range unchoked {
+		if ok {
+			t.unchoked[id.(*peer)] = true
 		}
-		exists = true
 	}
-
-	return
+	t.unchokedM.Unlock()
 }
 
 func minInt64(a, b int64) int64 {